@@ -0,0 +1,942 @@
+package common
+
+import (
+	"container/heap"
+	"container/list"
+	"context"
+	"encoding/gob"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Key is used as the key type for items stored in the Cache.
+type Key interface{}
+
+// Value is used as the value type for items stored in the Cache.
+type Value interface{}
+
+// clock returns the current time. It exists so tests can substitute a fake
+// clock instead of time.Now.
+type clock func() time.Time
+
+// RemovalListener is invoked with the key and value of an entry that has
+// just been evicted from the cache because it expired or was evicted to keep
+// the cache within its capacity. It is not invoked for entries removed by
+// Delete or overwritten by Put/Replace. It is kept for callers of
+// newCache/NewCacheWithRemovalListener; new code should subscribe with
+// OnEviction instead, which also reports the Reason.
+type RemovalListener func(k Key, v Value)
+
+// Reason identifies why an entry was evicted from the cache, as reported to
+// an EvictionListener.
+type Reason int
+
+const (
+	// ReasonExpired means the entry's TTL elapsed.
+	ReasonExpired Reason = iota
+	// ReasonCapacity means the entry was the least recently used one and
+	// was evicted to keep the cache at or under its capacity.
+	ReasonCapacity
+	// ReasonReplaced means the entry was overwritten by a Put or Replace
+	// call for the same key.
+	ReasonReplaced
+	// ReasonManual means the entry was removed by an explicit Delete call.
+	ReasonManual
+)
+
+// InsertionListener is invoked with the key and value of an entry that was
+// just inserted into the cache, registered with OnInsertion.
+type InsertionListener func(k Key, v Value)
+
+// EvictionListener is invoked with the key, value, and Reason of an entry
+// that was just evicted from the cache, registered with OnEviction.
+type EvictionListener func(k Key, v Value, reason Reason)
+
+// subQueue delivers events to a single subscriber one at a time, in the
+// order they were pushed, via a dedicated worker goroutine. This lets
+// notifyInsertionSubs/notifyEvictionSubs hand an event off without blocking
+// on a slow or blocking subscriber, while still guaranteeing that the same
+// subscriber never sees two events out of order.
+type subQueue struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	events []func()
+	closed bool
+}
+
+func newSubQueue() *subQueue {
+	q := &subQueue{}
+	q.cond = sync.NewCond(&q.mu)
+	go q.run()
+	return q
+}
+
+func (q *subQueue) push(fn func()) {
+	q.mu.Lock()
+	q.events = append(q.events, fn)
+	q.mu.Unlock()
+	q.cond.Signal()
+}
+
+// close lets the worker goroutine exit once it has drained any events
+// already queued; it does not discard pending deliveries.
+func (q *subQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.cond.Signal()
+}
+
+func (q *subQueue) run() {
+	for {
+		q.mu.Lock()
+		for len(q.events) == 0 && !q.closed {
+			q.cond.Wait()
+		}
+		if len(q.events) == 0 {
+			q.mu.Unlock()
+			return
+		}
+		fn := q.events[0]
+		q.events = q.events[1:]
+		q.mu.Unlock()
+
+		fn()
+	}
+}
+
+type insertionSub struct {
+	fn    InsertionListener
+	queue *subQueue
+}
+
+type evictionSub struct {
+	fn    EvictionListener
+	queue *subQueue
+}
+
+// entry is a single element stored in the cache. heapIndex is maintained by
+// container/heap and lets the janitor remove or re-prioritize an entry in
+// O(log n) without scanning the whole queue. lru is this entry's node in
+// the cache's LRU list, used for capacity-based eviction.
+type entry struct {
+	key        Key
+	value      Value
+	expiration time.Time
+	access     time.Time
+	timeout    time.Duration
+	heapIndex  int
+	lru        *list.Element
+}
+
+func (e *entry) isExpired(now time.Time) bool {
+	return now.After(e.expiration)
+}
+
+// inflightCall tracks a GetOrLoad loader invocation in progress for a given
+// key, so concurrent callers for that key can wait for its result instead
+// of each calling the loader themselves.
+type inflightCall struct {
+	wg    sync.WaitGroup
+	value Value
+	err   error
+}
+
+// negativeEntry caches a loader error returned from GetOrLoad, so repeated
+// calls for a key whose loader is failing don't hammer it until
+// expiration. Only created when a Cache's loadFailureTTL is non-zero.
+type negativeEntry struct {
+	err        error
+	expiration time.Time
+}
+
+func (e *negativeEntry) isExpired(now time.Time) bool {
+	return now.After(e.expiration)
+}
+
+// expirationQueue is a min-heap of *entry ordered by expiration time, so the
+// entry due to expire next is always at the root.
+type expirationQueue []*entry
+
+func (q expirationQueue) Len() int { return len(q) }
+
+func (q expirationQueue) Less(i, j int) bool {
+	return q[i].expiration.Before(q[j].expiration)
+}
+
+func (q expirationQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].heapIndex = i
+	q[j].heapIndex = j
+}
+
+func (q *expirationQueue) Push(x interface{}) {
+	e := x.(*entry)
+	e.heapIndex = len(*q)
+	*q = append(*q, e)
+}
+
+func (q *expirationQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = nil
+	e.heapIndex = -1
+	*q = old[:n-1]
+	return e
+}
+
+// EvictionCounts breaks a Cache's eviction count down by Reason.
+type EvictionCounts struct {
+	Expired  uint64
+	Capacity uint64
+	Replaced uint64
+	Manual   uint64
+}
+
+// Metrics holds monotonically increasing counters describing a Cache's
+// activity since construction. A Metrics value is a snapshot: taking it
+// does not reset the counters.
+type Metrics struct {
+	Hits          uint64
+	Misses        uint64
+	Insertions    uint64
+	Evictions     EvictionCounts
+	LoaderCalls   uint64
+	LoaderLatency time.Duration // running sum across LoaderCalls
+}
+
+// MetricsListener is invoked with a snapshot of a Cache's Metrics after
+// every operation that changes them, so callers can bridge the counters to
+// Prometheus, OpenTelemetry, or similar without this package importing
+// either.
+type MetricsListener func(Metrics)
+
+// Cache is a simple, goroutine-safe cache where each entry has its own
+// time-to-live. Get ignores an expired entry but does not remove it; expired
+// entries are removed by CleanUp, by an optional janitor goroutine started
+// with StartJanitor, by Delete, or when a write to the same key replaces
+// them.
+type Cache struct {
+	sync.Mutex
+	expiration time.Duration
+	table      map[Key]*entry
+	queue      expirationQueue
+	lru        *list.List
+	capacity   int
+	clock      clock
+
+	loadFailureTTL time.Duration
+	inflight       map[Key]*inflightCall
+	negative       map[Key]*negativeEntry
+
+	metrics         Metrics
+	metricsListener MetricsListener
+
+	// subMu guards insertionSubs/evictionSubs. It is distinct from the
+	// embedded Mutex guarding the cache's data so that a subscriber
+	// calling back into the cache from its own goroutine can never
+	// deadlock against the subscription that dispatched it.
+	subMu         sync.RWMutex
+	nextSubID     uint64
+	insertionSubs map[uint64]*insertionSub
+	evictionSubs  map[uint64]*evictionSub
+
+	janitorStop chan struct{}
+	wake        chan struct{}
+}
+
+// newCache creates a Cache whose entries expire after expiration by
+// default, sized for initialSize entries up front. listener, if non-nil, is
+// invoked whenever an entry expires or is evicted for capacity. clock is
+// used to read the current
+// time and exists so tests can fake it.
+func newCache(expiration time.Duration, initialSize int, listener RemovalListener, clock clock) *Cache {
+	c := &Cache{
+		expiration: expiration,
+		table:      make(map[Key]*entry, initialSize),
+		queue:      make(expirationQueue, 0, initialSize),
+		lru:        list.New(),
+		clock:      clock,
+	}
+	if listener != nil {
+		// Preserve the historical behavior of the plain RemovalListener:
+		// it only ever fired for expiry and capacity evictions, since
+		// replace/manual evictions are already visible synchronously
+		// through the return value of Replace/Put/Delete.
+		c.OnEviction(func(k Key, v Value, reason Reason) {
+			if reason == ReasonExpired || reason == ReasonCapacity {
+				listener(k, v)
+			}
+		})
+	}
+	return c
+}
+
+// NewCache creates a Cache whose entries expire after expiration by
+// default, sized for initialSize entries up front.
+func NewCache(expiration time.Duration, initialSize int) *Cache {
+	return newCache(expiration, initialSize, nil, time.Now)
+}
+
+// NewCacheWithRemovalListener creates a Cache like NewCache, additionally
+// invoking listener whenever an entry expires or is evicted for capacity.
+func NewCacheWithRemovalListener(expiration time.Duration, initialSize int, listener RemovalListener) *Cache {
+	return newCache(expiration, initialSize, listener, time.Now)
+}
+
+// SetCapacity bounds the number of entries the cache will hold. Once the
+// bound is reached, inserting a new key evicts the least recently used
+// entry (as tracked by Get and successful Put/Replace/PutIfAbsent calls).
+// A capacity of 0, the default, means unbounded. SetCapacity is not safe to
+// call concurrently with cache operations other than at construction time.
+func (c *Cache) SetCapacity(capacity int) {
+	c.capacity = capacity
+}
+
+// OnInsertion registers fn to be called whenever an entry is inserted into
+// the cache, until the returned unsubscribe function is called. Deliveries
+// to fn happen one at a time, in the order the insertions occurred, on a
+// dedicated goroutine, so a slow or blocking subscriber cannot delay the
+// Put/Replace/PutIfAbsent call that triggered it.
+func (c *Cache) OnInsertion(fn InsertionListener) (unsubscribe func()) {
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
+
+	id := c.nextSubID
+	c.nextSubID++
+	if c.insertionSubs == nil {
+		c.insertionSubs = make(map[uint64]*insertionSub)
+	}
+	sub := &insertionSub{fn: fn, queue: newSubQueue()}
+	c.insertionSubs[id] = sub
+
+	return func() {
+		c.subMu.Lock()
+		defer c.subMu.Unlock()
+		delete(c.insertionSubs, id)
+		sub.queue.close()
+	}
+}
+
+// OnEviction registers fn to be called whenever an entry is evicted from
+// the cache, until the returned unsubscribe function is called. Deliveries
+// to fn happen one at a time, in the order the evictions occurred, on a
+// dedicated goroutine, so a slow or blocking subscriber cannot stall the
+// janitor or delay the cache mutation that triggered it.
+func (c *Cache) OnEviction(fn EvictionListener) (unsubscribe func()) {
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
+
+	id := c.nextSubID
+	c.nextSubID++
+	if c.evictionSubs == nil {
+		c.evictionSubs = make(map[uint64]*evictionSub)
+	}
+	sub := &evictionSub{fn: fn, queue: newSubQueue()}
+	c.evictionSubs[id] = sub
+
+	return func() {
+		c.subMu.Lock()
+		defer c.subMu.Unlock()
+		delete(c.evictionSubs, id)
+		sub.queue.close()
+	}
+}
+
+// notifyInsertionSubs fans k/v out to every insertion subscriber's queue, so
+// each subscriber sees its events in order without blocking on the others.
+func (c *Cache) notifyInsertionSubs(k Key, v Value) {
+	c.subMu.RLock()
+	defer c.subMu.RUnlock()
+	for _, sub := range c.insertionSubs {
+		sub := sub
+		sub.queue.push(func() { sub.fn(k, v) })
+	}
+}
+
+// notifyEvictionSubs fans k/v/reason out to every eviction subscriber's
+// queue, so each subscriber sees its events in order without blocking on
+// the others.
+func (c *Cache) notifyEvictionSubs(k Key, v Value, reason Reason) {
+	c.subMu.RLock()
+	defer c.subMu.RUnlock()
+	for _, sub := range c.evictionSubs {
+		sub := sub
+		sub.queue.push(func() { sub.fn(k, v, reason) })
+	}
+}
+
+// SetLoadFailureTTL makes GetOrLoad and GetOrLoadCtx negatively cache
+// loader errors for ttl, so a key whose loader keeps failing isn't
+// reloaded by every caller. The default, 0, never caches errors.
+func (c *Cache) SetLoadFailureTTL(ttl time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	c.loadFailureTTL = ttl
+}
+
+// SetMetricsListener registers a callback invoked with a snapshot of the
+// cache's Metrics after every operation that changes them.
+func (c *Cache) SetMetricsListener(listener MetricsListener) {
+	c.Lock()
+	defer c.Unlock()
+	c.metricsListener = listener
+}
+
+// Metrics returns a snapshot of the cache's counters.
+func (c *Cache) Metrics() Metrics {
+	c.Lock()
+	defer c.Unlock()
+	return c.metrics
+}
+
+// reportMetricsLocked notifies metricsListener, if any, of the current
+// Metrics. Caller must hold c.Lock.
+func (c *Cache) reportMetricsLocked() {
+	if c.metricsListener != nil {
+		c.metricsListener(c.metrics)
+	}
+}
+
+// ttlFor returns timeout if it is set, or the cache's default expiration
+// otherwise.
+func (c *Cache) ttlFor(timeout time.Duration) time.Duration {
+	if timeout != 0 {
+		return timeout
+	}
+	return c.expiration
+}
+
+// insertLocked stores a fresh entry for k, reusing old's heap and LRU slots
+// if one exists so the queue and LRU list never grow beyond one entry per
+// key. It returns the entry that was replaced, or nil if k was not present.
+// Caller must hold c.Lock.
+func (c *Cache) insertLocked(k Key, v Value, timeout time.Duration, now time.Time) *entry {
+	old, ok := c.table[k]
+
+	t := c.ttlFor(timeout)
+	e := &entry{key: k, value: v, expiration: now.Add(t), access: now, timeout: t}
+	c.table[k] = e
+	if ok {
+		e.heapIndex = old.heapIndex
+		c.queue[e.heapIndex] = e
+		heap.Fix(&c.queue, e.heapIndex)
+
+		e.lru = old.lru
+		e.lru.Value = e
+		c.lru.MoveToBack(e.lru)
+	} else {
+		heap.Push(&c.queue, e)
+		e.lru = c.lru.PushBack(e)
+	}
+	c.wakeJanitor()
+
+	if ok {
+		reason := ReasonReplaced
+		if old.isExpired(now) {
+			reason = ReasonExpired
+		}
+		c.notifyEviction(old, reason)
+	}
+
+	c.evictOverCapacityLocked()
+
+	c.metrics.Insertions++
+	c.reportMetricsLocked()
+	c.notifyInsertionSubs(k, v)
+	return old
+}
+
+// removeLocked deletes e from the table, the expiration queue, and the LRU
+// list. Caller must hold c.Lock.
+func (c *Cache) removeLocked(e *entry) {
+	delete(c.table, e.key)
+	if e.heapIndex >= 0 {
+		heap.Remove(&c.queue, e.heapIndex)
+	}
+	if e.lru != nil {
+		c.lru.Remove(e.lru)
+	}
+}
+
+// evictOverCapacityLocked evicts the least recently used entries until the
+// cache is at or under its capacity. It is a no-op when capacity is 0
+// (unbounded). Caller must hold c.Lock.
+func (c *Cache) evictOverCapacityLocked() {
+	if c.capacity <= 0 {
+		return
+	}
+	for len(c.table) > c.capacity {
+		front := c.lru.Front()
+		if front == nil {
+			return
+		}
+		e := front.Value.(*entry)
+		c.removeLocked(e)
+		c.notifyEviction(e, ReasonCapacity)
+	}
+}
+
+// notifyEviction records an entry leaving the cache in the Evictions
+// metrics and fans it out to every OnEviction subscriber. Caller must hold
+// c.Lock.
+func (c *Cache) notifyEviction(e *entry, reason Reason) {
+	switch reason {
+	case ReasonExpired:
+		c.metrics.Evictions.Expired++
+	case ReasonCapacity:
+		c.metrics.Evictions.Capacity++
+	case ReasonReplaced:
+		c.metrics.Evictions.Replaced++
+	case ReasonManual:
+		c.metrics.Evictions.Manual++
+	}
+	c.reportMetricsLocked()
+	c.notifyEvictionSubs(e.key, e.value, reason)
+}
+
+// Put adds the key/value to the cache, replacing any existing value. The
+// entry expires after timeout, or after the cache's default expiration if
+// timeout is 0. The previous value is returned, or nil if there wasn't one
+// or it had already expired.
+func (c *Cache) Put(k Key, v Value, timeout time.Duration) Value {
+	c.Lock()
+	defer c.Unlock()
+
+	now := c.clock()
+	old := c.insertLocked(k, v, timeout, now)
+	if old != nil && !old.isExpired(now) {
+		return old.value
+	}
+	return nil
+}
+
+// PutIfAbsent adds the key/value to the cache only if there is no
+// non-expired value already stored for k. It returns the existing value,
+// or nil if k was absent (in which case v was stored).
+func (c *Cache) PutIfAbsent(k Key, v Value, timeout time.Duration) Value {
+	c.Lock()
+	defer c.Unlock()
+
+	now := c.clock()
+	if old, ok := c.table[k]; ok && !old.isExpired(now) {
+		return old.value
+	}
+
+	c.insertLocked(k, v, timeout, now)
+	return nil
+}
+
+// Replace overwrites the value for k only if it is already present,
+// returning the previous value (nil if it had already expired), or nil
+// without storing anything if k was absent.
+func (c *Cache) Replace(k Key, v Value, timeout time.Duration) Value {
+	c.Lock()
+	defer c.Unlock()
+
+	now := c.clock()
+	old, ok := c.table[k]
+	if !ok {
+		return nil
+	}
+
+	c.insertLocked(k, v, timeout, now)
+	if old.isExpired(now) {
+		return nil
+	}
+	return old.value
+}
+
+// Get returns the value stored for k, or nil if it is absent or expired.
+// A successful Get refreshes k's expiration using its original timeout, so
+// entries retrieved with a sliding TTL stay alive as long as they keep
+// being accessed.
+func (c *Cache) Get(k Key) Value {
+	c.Lock()
+	defer c.Unlock()
+	return c.getLocked(k)
+}
+
+// getLocked is Get without acquiring c.Lock, for callers that already hold
+// it. Caller must hold c.Lock.
+func (c *Cache) getLocked(k Key) Value {
+	e, ok := c.table[k]
+	if !ok {
+		c.metrics.Misses++
+		c.reportMetricsLocked()
+		return nil
+	}
+
+	now := c.clock()
+	if e.isExpired(now) {
+		c.metrics.Misses++
+		c.reportMetricsLocked()
+		return nil
+	}
+
+	e.access = now
+	e.expiration = now.Add(e.timeout)
+	heap.Fix(&c.queue, e.heapIndex)
+	c.lru.MoveToBack(e.lru)
+	c.wakeJanitor()
+
+	c.metrics.Hits++
+	c.reportMetricsLocked()
+	return e.value
+}
+
+// GetOrLoad returns the cached value for k if present and non-expired.
+// Otherwise it calls loader exactly once, even if multiple goroutines call
+// GetOrLoad for the same k concurrently; all of them receive the result of
+// that single call. The returned value, if any, is cached using loader's
+// returned duration as its timeout (0 meaning the cache's default
+// expiration). Loader errors are not cached unless SetLoadFailureTTL has
+// been called.
+func (c *Cache) GetOrLoad(k Key, loader func(Key) (Value, time.Duration, error)) (Value, error) {
+	return c.GetOrLoadCtx(context.Background(), k, func(_ context.Context, k Key) (Value, time.Duration, error) {
+		return loader(k)
+	})
+}
+
+// GetOrLoadCtx is like GetOrLoad, but passes ctx through to loader so a
+// long-running load can be cancelled.
+func (c *Cache) GetOrLoadCtx(ctx context.Context, k Key, loader func(context.Context, Key) (Value, time.Duration, error)) (Value, error) {
+	c.Lock()
+	if v := c.getLocked(k); v != nil {
+		c.Unlock()
+		return v, nil
+	}
+
+	now := c.clock()
+	if neg, ok := c.negative[k]; ok {
+		if !neg.isExpired(now) {
+			c.Unlock()
+			return nil, neg.err
+		}
+		delete(c.negative, k)
+	}
+
+	if call, ok := c.inflight[k]; ok {
+		c.Unlock()
+		call.wg.Wait()
+		return call.value, call.err
+	}
+
+	call := &inflightCall{}
+	call.wg.Add(1)
+	if c.inflight == nil {
+		c.inflight = make(map[Key]*inflightCall)
+	}
+	c.inflight[k] = call
+	c.Unlock()
+
+	start := time.Now()
+	value, timeout, err := loader(ctx, k)
+	latency := time.Since(start)
+
+	c.Lock()
+	delete(c.inflight, k)
+	c.metrics.LoaderCalls++
+	c.metrics.LoaderLatency += latency
+	c.reportMetricsLocked()
+	if err != nil {
+		if c.loadFailureTTL > 0 {
+			if c.negative == nil {
+				c.negative = make(map[Key]*negativeEntry)
+			}
+			c.negative[k] = &negativeEntry{err: err, expiration: c.clock().Add(c.loadFailureTTL)}
+		}
+	} else {
+		c.insertLocked(k, value, timeout, c.clock())
+	}
+	c.Unlock()
+
+	call.value, call.err = value, err
+	call.wg.Done()
+	return value, err
+}
+
+// Delete removes k from the cache and returns its value, or nil if k was
+// absent or already expired.
+func (c *Cache) Delete(k Key) Value {
+	c.Lock()
+	defer c.Unlock()
+
+	e, ok := c.table[k]
+	if !ok {
+		return nil
+	}
+	c.removeLocked(e)
+	c.notifyEviction(e, ReasonManual)
+
+	if e.isExpired(c.clock()) {
+		return nil
+	}
+	return e.value
+}
+
+// Size returns the number of entries in the cache, including expired ones
+// that have not yet been cleaned up.
+func (c *Cache) Size() int {
+	c.Lock()
+	defer c.Unlock()
+	return len(c.table)
+}
+
+// Entries returns a snapshot of the non-expired key/value pairs in the
+// cache.
+func (c *Cache) Entries() map[Key]Value {
+	c.Lock()
+	defer c.Unlock()
+
+	now := c.clock()
+	m := make(map[Key]Value, len(c.table))
+	for k, e := range c.table {
+		if !e.isExpired(now) {
+			m[k] = e.value
+		}
+	}
+	return m
+}
+
+// savedEntry is the gob-encoded representation of a single entry written by
+// Save and read back by Load.
+type savedEntry struct {
+	Key        Key
+	Value      Value
+	Expiration time.Time
+	Timeout    time.Duration
+}
+
+// Save gob-encodes every non-expired entry in the cache, together with its
+// absolute expiration and original timeout, and writes them to w. Since
+// Value is interface{}, the concrete type of every stored value must have
+// been registered with gob.Register beforehand, or encoding will fail.
+func (c *Cache) Save(w io.Writer) error {
+	c.Lock()
+	now := c.clock()
+	entries := make([]savedEntry, 0, len(c.table))
+	for _, e := range c.table {
+		if e.isExpired(now) {
+			continue
+		}
+		entries = append(entries, savedEntry{Key: e.key, Value: e.value, Expiration: e.expiration, Timeout: e.timeout})
+	}
+	c.Unlock()
+
+	return gob.NewEncoder(w).Encode(entries)
+}
+
+// SaveFile is a convenience wrapper around Save that (over)writes the file
+// at path.
+func (c *Cache) SaveFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return c.Save(f)
+}
+
+// LoadMode controls how Load treats a restored key that is already present
+// in the cache.
+type LoadMode int
+
+const (
+	// LoadReplace overwrites any existing entry for a restored key.
+	LoadReplace LoadMode = iota
+	// LoadKeepExisting leaves an existing non-expired entry for a restored
+	// key untouched, discarding the restored one.
+	LoadKeepExisting
+)
+
+// Load restores entries previously written by Save, computing each one's
+// remaining TTL as its stored absolute expiration minus the cache's
+// current clock time and dropping any entry that would already be
+// expired. mode controls what happens when a restored key is already
+// present in the cache. As with Save, the concrete type of every restored
+// value must have been registered with gob.Register beforehand.
+func (c *Cache) Load(r io.Reader, mode LoadMode) error {
+	var entries []savedEntry
+	if err := gob.NewDecoder(r).Decode(&entries); err != nil {
+		return err
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	now := c.clock()
+	for _, se := range entries {
+		if !se.Expiration.After(now) {
+			continue
+		}
+		if mode == LoadKeepExisting {
+			if old, ok := c.table[se.Key]; ok && !old.isExpired(now) {
+				continue
+			}
+		}
+		c.restoreLocked(se, now)
+	}
+	return nil
+}
+
+// LoadFile is a convenience wrapper around Load that reads from the file
+// at path.
+func (c *Cache) LoadFile(path string, mode LoadMode) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return c.Load(f, mode)
+}
+
+// restoreLocked inserts a restored entry, reusing an existing key's heap
+// and LRU slots the same way insertLocked does. Unlike a live Put, a key
+// that already holds a live value is overwritten silently - Load is a bulk
+// operation, not a sequence of individual writes, so there is no
+// ReasonReplaced notification for the entry restoreLocked overwrites.
+// Restoring past the cache's capacity, however, evicts exactly as Put
+// would: the least recently used entries are evicted with ReasonCapacity,
+// notifying OnEviction subscribers and updating Metrics.Evictions.Capacity.
+// Caller must hold c.Lock.
+func (c *Cache) restoreLocked(se savedEntry, now time.Time) {
+	old, ok := c.table[se.Key]
+
+	e := &entry{key: se.Key, value: se.Value, expiration: se.Expiration, access: now, timeout: se.Timeout}
+	c.table[se.Key] = e
+	if ok {
+		e.heapIndex = old.heapIndex
+		c.queue[e.heapIndex] = e
+		heap.Fix(&c.queue, e.heapIndex)
+		e.lru = old.lru
+		e.lru.Value = e
+		c.lru.MoveToBack(e.lru)
+	} else {
+		heap.Push(&c.queue, e)
+		e.lru = c.lru.PushBack(e)
+	}
+	c.wakeJanitor()
+	c.evictOverCapacityLocked()
+}
+
+// CleanUp removes every currently expired entry from the cache, invoking
+// the RemovalListener (if any) for each one, and returns the number of
+// entries removed. It is the manual counterpart to the janitor goroutine
+// started by StartJanitor and is always safe to call directly, e.g. from
+// tests that advance a fake clock.
+func (c *Cache) CleanUp() int {
+	c.Lock()
+	defer c.Unlock()
+
+	now := c.clock()
+	count := 0
+	for len(c.queue) > 0 && c.queue[0].isExpired(now) {
+		e := heap.Pop(&c.queue).(*entry)
+		delete(c.table, e.key)
+		if e.lru != nil {
+			c.lru.Remove(e.lru)
+		}
+		count++
+		c.notifyEviction(e, ReasonExpired)
+	}
+	return count
+}
+
+// nextWaitLocked returns how long the janitor should sleep before waking up to
+// evict entries, based on the entry at the top of the expiration queue.
+// fallback is used when the queue is empty, so an idle cache still wakes
+// occasionally to notice entries inserted without going through
+// wakeJanitor's notification (there are none today, but it keeps the
+// janitor from sleeping forever if that ever changes). Caller must hold
+// c.Lock.
+func (c *Cache) nextWaitLocked(fallback time.Duration) time.Duration {
+	if len(c.queue) == 0 {
+		return fallback
+	}
+	d := c.queue[0].expiration.Sub(c.clock())
+	if d < 0 {
+		d = 0
+	}
+	return d
+}
+
+// wakeJanitor notifies a running janitor goroutine that the top of the
+// expiration queue may have changed, so it should recompute its sleep
+// duration instead of waiting for its current timer to fire. Caller must
+// hold c.Lock.
+func (c *Cache) wakeJanitor() {
+	if c.wake == nil {
+		return
+	}
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// StartJanitor starts a goroutine that evicts expired entries as they fall
+// due, sleeping on a timer set to the next expiration in the queue instead
+// of polling the whole cache at a fixed cadence. interval is used only as
+// the sleep duration while the cache is empty; once it holds entries, the
+// janitor wakes exactly when the next one expires. Calling StartJanitor
+// while a janitor is already running is a no-op.
+func (c *Cache) StartJanitor(interval time.Duration) {
+	c.Lock()
+	if c.janitorStop != nil {
+		c.Unlock()
+		return
+	}
+	stop := make(chan struct{})
+	wake := make(chan struct{}, 1)
+	c.janitorStop = stop
+	c.wake = wake
+	c.Unlock()
+
+	go c.runJanitor(interval, stop, wake)
+}
+
+func (c *Cache) runJanitor(interval time.Duration, stop, wake chan struct{}) {
+	c.Lock()
+	timer := time.NewTimer(c.nextWaitLocked(interval))
+	c.Unlock()
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-wake:
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			c.Lock()
+			timer.Reset(c.nextWaitLocked(interval))
+			c.Unlock()
+		case <-timer.C:
+			c.CleanUp()
+			c.Lock()
+			timer.Reset(c.nextWaitLocked(interval))
+			c.Unlock()
+		}
+	}
+}
+
+// StopJanitor stops a janitor goroutine started with StartJanitor. It is a
+// no-op if no janitor is running.
+func (c *Cache) StopJanitor() {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.janitorStop != nil {
+		close(c.janitorStop)
+		c.janitorStop = nil
+		c.wake = nil
+	}
+}
@@ -1,12 +1,21 @@
 package common
 
 import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 )
 
+func init() {
+	gob.Register("")
+}
+
 const (
 	Timeout    time.Duration = 1 * time.Minute
 	InitalSize int           = 10
@@ -27,24 +36,26 @@ var (
 	}
 )
 
-// RemovalListener callback.
-var (
-	callbackKey     Key
-	callbackValue   Value
-	removalListener RemovalListener = func(k Key, v Value) {
+// Test that the removal listener is invoked with the expired key/value.
+// RemovalListener is now dispatched to its own goroutine (see OnEviction),
+// so the test synchronizes on a channel rather than reading shared state
+// right after CleanUp returns.
+func TestExpireWithRemovalListener(t *testing.T) {
+	called := make(chan struct{})
+	var callbackKey Key
+	var callbackValue Value
+	removalListener := RemovalListener(func(k Key, v Value) {
 		callbackKey = k
 		callbackValue = v
-	}
-)
+		close(called)
+	})
 
-// Test that the removal listener is invoked with the expired key/value.
-func TestExpireWithRemovalListener(t *testing.T) {
-	callbackKey = nil
-	callbackValue = nil
 	c := newCache(Timeout, InitalSize, removalListener, fakeClock)
 	c.Put(alphaKey, alphaValue, 0)
 	currentTime = currentTime.Add(Timeout).Add(time.Nanosecond)
 	assert.Equal(t, 1, c.CleanUp())
+
+	<-called
 	assert.Equal(t, alphaKey, callbackKey)
 	assert.Equal(t, alphaValue, callbackValue)
 }
@@ -151,6 +162,380 @@ func TestGetExpiredValue(t *testing.T) {
 	assert.Nil(t, v)
 }
 
+// eviction pairs a key/reason reported to an OnEviction subscriber.
+type eviction struct {
+	key    Key
+	reason Reason
+}
+
+// Test that setting a capacity evicts the least recently used entry once
+// the cache grows past it, and that the eviction subscriber reports it as
+// a capacity eviction rather than an expiry.
+func TestCapacityEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newCache(Timeout, InitalSize, nil, fakeClock)
+	c.SetCapacity(2)
+
+	evictions := make(chan eviction, 1)
+	c.OnEviction(func(k Key, v Value, reason Reason) {
+		evictions <- eviction{k, reason}
+	})
+
+	c.Put(alphaKey, alphaValue, 0)
+	c.Put(bravoKey, bravoValue, 0)
+	// Touch alphaKey so bravoKey becomes the least recently used entry.
+	c.Get(alphaKey)
+
+	c.Put("charlieKey", "c", 0)
+
+	got := <-evictions
+	assert.Equal(t, 2, c.Size())
+	assert.Equal(t, bravoKey, got.key)
+	assert.Equal(t, ReasonCapacity, got.reason)
+	assert.Nil(t, c.Get(bravoKey))
+}
+
+// Test that OnEviction subscribers distinguish explicit deletes from
+// replaced entries, and that unsubscribing stops delivery.
+func TestOnEvictionReasons(t *testing.T) {
+	c := newCache(Timeout, InitalSize, nil, fakeClock)
+
+	evictions := make(chan eviction, 3)
+	unsubscribe := c.OnEviction(func(k Key, v Value, reason Reason) {
+		evictions <- eviction{k, reason}
+	})
+
+	c.Put(alphaKey, alphaValue, 0)
+	c.Put(alphaKey, bravoValue, 0) // replaced
+	c.Delete(alphaKey)             // manual
+
+	var got []Reason
+	for i := 0; i < 2; i++ {
+		got = append(got, (<-evictions).reason)
+	}
+	assert.Equal(t, []Reason{ReasonReplaced, ReasonManual}, got)
+
+	unsubscribe()
+
+	c.Put(bravoKey, bravoValue, 0)
+	currentTime = currentTime.Add(Timeout).Add(time.Nanosecond)
+	c.CleanUp() // expired, but no longer subscribed
+
+	select {
+	case ev := <-evictions:
+		t.Fatalf("unexpected eviction delivered after unsubscribe: %+v", ev)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+// Test that OnInsertion subscribers are notified of every insertion,
+// including replacements.
+func TestOnInsertion(t *testing.T) {
+	c := newCache(Timeout, InitalSize, nil, fakeClock)
+
+	insertions := make(chan Key, 2)
+	c.OnInsertion(func(k Key, v Value) {
+		insertions <- k
+	})
+
+	c.Put(alphaKey, alphaValue, 0)
+	c.Put(alphaKey, bravoValue, 0)
+
+	assert.Equal(t, alphaKey, <-insertions)
+	assert.Equal(t, alphaKey, <-insertions)
+}
+
+// Test that GetOrLoad calls the loader on a miss and caches its result.
+func TestGetOrLoadMiss(t *testing.T) {
+	c := newCache(Timeout, InitalSize, nil, fakeClock)
+
+	var calls int32
+	v, err := c.GetOrLoad(alphaKey, func(k Key) (Value, time.Duration, error) {
+		atomic.AddInt32(&calls, 1)
+		return alphaValue, 0, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, alphaValue, v)
+	assert.Equal(t, int32(1), calls)
+	assert.Equal(t, alphaValue, c.Get(alphaKey))
+}
+
+// Test that GetOrLoad returns the cached value without calling the loader
+// on a hit.
+func TestGetOrLoadHit(t *testing.T) {
+	c := newCache(Timeout, InitalSize, nil, fakeClock)
+	c.Put(alphaKey, alphaValue, 0)
+
+	v, err := c.GetOrLoad(alphaKey, func(k Key) (Value, time.Duration, error) {
+		t.Fatal("loader should not be called on a cache hit")
+		return nil, 0, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, alphaValue, v)
+}
+
+// Test that concurrent GetOrLoad calls for the same key invoke the loader
+// exactly once and deliver its result to every caller.
+func TestGetOrLoadDeduplicatesConcurrentMisses(t *testing.T) {
+	c := newCache(Timeout, InitalSize, nil, fakeClock)
+
+	var calls int32
+	release := make(chan struct{})
+	loader := func(k Key) (Value, time.Duration, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return alphaValue, 0, nil
+	}
+
+	const n = 10
+	var wg sync.WaitGroup
+	results := make([]Value, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			v, err := c.GetOrLoad(alphaKey, loader)
+			assert.NoError(t, err)
+			results[i] = v
+		}(i)
+	}
+
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), calls)
+	for _, v := range results {
+		assert.Equal(t, alphaValue, v)
+	}
+}
+
+// Test that loader errors are not cached by default, so a subsequent
+// GetOrLoad retries the loader.
+func TestGetOrLoadErrorsNotCachedByDefault(t *testing.T) {
+	c := newCache(Timeout, InitalSize, nil, fakeClock)
+	wantErr := errors.New("boom")
+
+	var calls int32
+	loader := func(k Key) (Value, time.Duration, error) {
+		atomic.AddInt32(&calls, 1)
+		return nil, 0, wantErr
+	}
+
+	_, err := c.GetOrLoad(alphaKey, loader)
+	assert.Equal(t, wantErr, err)
+	_, err = c.GetOrLoad(alphaKey, loader)
+	assert.Equal(t, wantErr, err)
+	assert.Equal(t, int32(2), calls)
+}
+
+// Test that SetLoadFailureTTL negatively caches a loader error until it
+// expires.
+func TestGetOrLoadNegativeCaching(t *testing.T) {
+	c := newCache(Timeout, InitalSize, nil, fakeClock)
+	c.SetLoadFailureTTL(Timeout)
+	wantErr := errors.New("boom")
+
+	var calls int32
+	loader := func(k Key) (Value, time.Duration, error) {
+		atomic.AddInt32(&calls, 1)
+		return nil, 0, wantErr
+	}
+
+	_, err := c.GetOrLoad(alphaKey, loader)
+	assert.Equal(t, wantErr, err)
+	_, err = c.GetOrLoad(alphaKey, loader)
+	assert.Equal(t, wantErr, err)
+	assert.Equal(t, int32(1), calls)
+
+	currentTime = currentTime.Add(Timeout).Add(time.Nanosecond)
+	_, err = c.GetOrLoad(alphaKey, loader)
+	assert.Equal(t, wantErr, err)
+	assert.Equal(t, int32(2), calls)
+}
+
+// Test that Save followed by Load round-trips the non-expired entries.
+func TestSaveLoadRoundTrip(t *testing.T) {
+	c := newCache(Timeout, InitalSize, nil, fakeClock)
+	c.Put(alphaKey, alphaValue, 0)
+	currentTime = currentTime.Add(Timeout).Add(time.Nanosecond)
+	c.Put(bravoKey, bravoValue, 0) // still live; alphaKey is now expired
+
+	var buf bytes.Buffer
+	assert.NoError(t, c.Save(&buf))
+
+	restored := newCache(Timeout, InitalSize, nil, fakeClock)
+	assert.NoError(t, restored.Load(&buf, LoadReplace))
+
+	assert.Equal(t, 1, restored.Size())
+	assert.Equal(t, bravoValue, restored.Get(bravoKey))
+	assert.Nil(t, restored.Get(alphaKey))
+}
+
+// Test that Load restores the remaining TTL relative to the loading
+// cache's clock, not the full original timeout.
+func TestLoadRestoresRemainingTTL(t *testing.T) {
+	c := newCache(Timeout, InitalSize, nil, fakeClock)
+	c.Put(alphaKey, alphaValue, 0)
+
+	var buf bytes.Buffer
+	assert.NoError(t, c.Save(&buf))
+
+	// Simulate time passing between the save and the restore.
+	currentTime = currentTime.Add(Timeout / 2)
+
+	restored := newCache(Timeout, InitalSize, nil, fakeClock)
+	assert.NoError(t, restored.Load(&buf, LoadReplace))
+	// Peek via Entries rather than Get: Get slides the entry's expiration
+	// forward on every hit, which would clobber the remaining TTL this test
+	// is trying to observe.
+	assert.Equal(t, alphaValue, restored.Entries()[alphaKey])
+
+	// Only the remaining half of the original timeout should be left.
+	currentTime = currentTime.Add(Timeout / 2).Add(time.Nanosecond)
+	assert.Nil(t, restored.Get(alphaKey))
+}
+
+// Test that LoadKeepExisting does not overwrite a live entry already in
+// the cache, while LoadReplace does.
+func TestLoadMergeModes(t *testing.T) {
+	src := newCache(Timeout, InitalSize, nil, fakeClock)
+	src.Put(alphaKey, bravoValue, 0)
+
+	var buf bytes.Buffer
+	assert.NoError(t, src.Save(&buf))
+	saved := buf.Bytes()
+
+	dst := newCache(Timeout, InitalSize, nil, fakeClock)
+	dst.Put(alphaKey, alphaValue, 0)
+	assert.NoError(t, dst.Load(bytes.NewReader(saved), LoadKeepExisting))
+	assert.Equal(t, alphaValue, dst.Get(alphaKey))
+
+	assert.NoError(t, dst.Load(bytes.NewReader(saved), LoadReplace))
+	assert.Equal(t, bravoValue, dst.Get(alphaKey))
+}
+
+// Test that restoring entries past a cache's capacity evicts the least
+// recently used ones, the same as a live Put would, notifying OnEviction
+// subscribers with ReasonCapacity.
+func TestLoadEvictsOverCapacity(t *testing.T) {
+	src := newCache(Timeout, InitalSize, nil, fakeClock)
+	src.Put(alphaKey, alphaValue, 0)
+	src.Put(bravoKey, bravoValue, 0)
+
+	var buf bytes.Buffer
+	assert.NoError(t, src.Save(&buf))
+
+	dst := newCache(Timeout, InitalSize, nil, fakeClock)
+	dst.SetCapacity(1)
+
+	evictions := make(chan eviction, 1)
+	dst.OnEviction(func(k Key, v Value, reason Reason) {
+		evictions <- eviction{k, reason}
+	})
+
+	assert.NoError(t, dst.Load(&buf, LoadReplace))
+
+	assert.Equal(t, 1, dst.Size())
+	got := <-evictions
+	assert.Equal(t, ReasonCapacity, got.reason)
+	assert.Equal(t, uint64(1), dst.Metrics().Evictions.Capacity)
+}
+
+// Test that restoring an entry whose expiration is sooner than the
+// janitor's current timer wakes the janitor to recompute its sleep,
+// instead of leaving the entry to sit past expiration until the existing
+// (much longer) timer happens to fire. Uses the real clock, like
+// benchmarkPutContention, since this exercises the janitor's real timer.
+func TestLoadWakesJanitor(t *testing.T) {
+	c := newCache(time.Hour, InitalSize, nil, time.Now)
+	evictions := make(chan eviction, 1)
+	c.OnEviction(func(k Key, v Value, reason Reason) {
+		evictions <- eviction{k, reason}
+	})
+	// Empty queue, so the janitor's timer is set to the full hour-long
+	// fallback interval. Give the janitor goroutine time to set that
+	// initial timer before Load races ahead of it.
+	c.StartJanitor(time.Hour)
+	defer c.StopJanitor()
+	time.Sleep(20 * time.Millisecond)
+
+	src := newCache(100*time.Millisecond, InitalSize, nil, time.Now)
+	src.Put(bravoKey, bravoValue, 0)
+	var buf bytes.Buffer
+	assert.NoError(t, src.Save(&buf))
+
+	// Without a wakeJanitor call, the janitor stays asleep on its
+	// hour-long timer and won't notice bravoKey's much sooner expiration.
+	assert.NoError(t, c.Load(&buf, LoadReplace))
+
+	select {
+	case got := <-evictions:
+		assert.Equal(t, bravoKey, got.key)
+		assert.Equal(t, ReasonExpired, got.reason)
+	case <-time.After(2 * time.Second):
+		t.Fatal("janitor never woke up to expire the restored entry")
+	}
+}
+
+// Test that Metrics reflects a mixed sequence of hits, misses, insertions,
+// and evictions by reason.
+func TestMetrics(t *testing.T) {
+	c := newCache(Timeout, InitalSize, nil, fakeClock)
+
+	c.Put(alphaKey, alphaValue, 0) // insertion
+	c.Get(alphaKey)                // hit
+	c.Get(bravoKey)                // miss
+	c.Put(alphaKey, bravoValue, 0) // insertion + replaced eviction
+	c.Delete(alphaKey)             // manual eviction
+
+	c.Put(bravoKey, bravoValue, 0) // insertion
+	currentTime = currentTime.Add(Timeout).Add(time.Nanosecond)
+	c.CleanUp() // expired eviction
+
+	m := c.Metrics()
+	assert.Equal(t, uint64(1), m.Hits)
+	assert.Equal(t, uint64(1), m.Misses)
+	assert.Equal(t, uint64(3), m.Insertions)
+	assert.Equal(t, uint64(1), m.Evictions.Replaced)
+	assert.Equal(t, uint64(1), m.Evictions.Manual)
+	assert.Equal(t, uint64(1), m.Evictions.Expired)
+}
+
+// Test that GetOrLoad updates LoaderCalls and LoaderLatency only for the
+// actual loader invocation, not for cache hits.
+func TestMetricsLoaderCalls(t *testing.T) {
+	c := newCache(Timeout, InitalSize, nil, fakeClock)
+
+	_, err := c.GetOrLoad(alphaKey, func(k Key) (Value, time.Duration, error) {
+		return alphaValue, 0, nil
+	})
+	assert.NoError(t, err)
+	c.GetOrLoad(alphaKey, func(k Key) (Value, time.Duration, error) {
+		t.Fatal("loader should not be called again on a hit")
+		return nil, 0, nil
+	})
+
+	m := c.Metrics()
+	assert.Equal(t, uint64(1), m.LoaderCalls)
+	assert.GreaterOrEqual(t, m.LoaderLatency, time.Duration(0))
+}
+
+// Test that SetMetricsListener is notified after metrics-affecting
+// operations.
+func TestMetricsListener(t *testing.T) {
+	c := newCache(Timeout, InitalSize, nil, fakeClock)
+
+	var last Metrics
+	var calls int
+	c.SetMetricsListener(func(m Metrics) {
+		calls++
+		last = m
+	})
+
+	c.Put(alphaKey, alphaValue, 0)
+	assert.True(t, calls > 0)
+	assert.Equal(t, uint64(1), last.Insertions)
+}
+
 // Test that the janitor invokes CleanUp on the cache and that the
 // RemovalListener is invoked during clean up.
 func TestJanitor(t *testing.T) {
@@ -165,3 +550,30 @@ func TestJanitor(t *testing.T) {
 	c.StopJanitor()
 	assert.Equal(t, alphaKey, key)
 }
+
+// benchmarkPutContention hammers Put for a fixed set of keys from multiple
+// goroutines, so capacity eviction (when capacity > 0) is contending with
+// other Puts for c.Lock on every iteration.
+func benchmarkPutContention(b *testing.B, capacity int) {
+	c := newCache(time.Hour, InitalSize, nil, time.Now)
+	if capacity > 0 {
+		c.SetCapacity(capacity)
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			c.Put(i%1000, i, 0)
+			i++
+		}
+	})
+}
+
+func BenchmarkPutUnbounded(b *testing.B) {
+	benchmarkPutContention(b, 0)
+}
+
+func BenchmarkPutBoundedCapacity100(b *testing.B) {
+	benchmarkPutContention(b, 100)
+}
@@ -0,0 +1,409 @@
+// Package cachev2 provides a generic counterpart to common.Cache. It
+// mirrors the same Put/Get/Delete/Replace/PutIfAbsent/Entries/CleanUp
+// operations, but is parameterized over its key and value types instead of
+// boxing them as interface{}, and is configured through functional options
+// rather than newCache's fixed positional arguments.
+package cachev2
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/zzugg/libbeat/common"
+)
+
+// RemovalListener is invoked with the key and value of an entry that has
+// just been evicted by CleanUp (expiry) or to keep the cache within its
+// capacity. It is not invoked for entries removed by Delete or overwritten
+// by Put/Replace.
+type RemovalListener[K comparable, V any] func(k K, v V)
+
+type entry[K comparable, V any] struct {
+	key        K
+	value      V
+	expiration time.Time
+	timeout    time.Duration
+	order      *list.Element
+}
+
+// isExpired reports whether e has expired as of now. An entry stored with a
+// zero timeout never expires.
+func (e *entry[K, V]) isExpired(now time.Time) bool {
+	return e.timeout != 0 && now.After(e.expiration)
+}
+
+// config collects the options passed to New.
+type config[K comparable, V any] struct {
+	ttl             time.Duration
+	capacity        int
+	clock           func() time.Time
+	removalListener RemovalListener[K, V]
+	janitorInterval time.Duration
+}
+
+// Option configures a Cache created with New.
+type Option[K comparable, V any] func(*config[K, V])
+
+// WithTTL sets the default time-to-live used by Put, PutIfAbsent, and
+// Replace when called with a zero timeout. The zero value means entries
+// never expire on their own.
+func WithTTL[K comparable, V any](ttl time.Duration) Option[K, V] {
+	return func(c *config[K, V]) { c.ttl = ttl }
+}
+
+// WithCapacity bounds the number of entries the cache holds. Once the bound
+// is reached, inserting a new key evicts the oldest entry still present,
+// in insertion order.
+func WithCapacity[K comparable, V any](capacity int) Option[K, V] {
+	return func(c *config[K, V]) { c.capacity = capacity }
+}
+
+// WithClock overrides time.Now, for tests that need deterministic expiry.
+func WithClock[K comparable, V any](clock func() time.Time) Option[K, V] {
+	return func(c *config[K, V]) { c.clock = clock }
+}
+
+// WithRemovalListener registers a callback invoked whenever an entry is
+// evicted.
+func WithRemovalListener[K comparable, V any](listener RemovalListener[K, V]) Option[K, V] {
+	return func(c *config[K, V]) { c.removalListener = listener }
+}
+
+// WithJanitorInterval starts a background goroutine that sweeps expired
+// entries on the given interval. Without this option, expired entries are
+// only removed lazily or via an explicit CleanUp call.
+func WithJanitorInterval[K comparable, V any](interval time.Duration) Option[K, V] {
+	return func(c *config[K, V]) { c.janitorInterval = interval }
+}
+
+// Cache is a generic, goroutine-safe, expiring cache.
+type Cache[K comparable, V any] struct {
+	mu    sync.Mutex
+	table map[K]*entry[K, V]
+	order *list.List
+
+	ttl             time.Duration
+	capacity        int
+	clock           func() time.Time
+	removalListener RemovalListener[K, V]
+
+	janitorStop chan struct{}
+}
+
+// New creates a Cache configured by opts.
+func New[K comparable, V any](opts ...Option[K, V]) *Cache[K, V] {
+	cfg := config[K, V]{clock: time.Now}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	c := &Cache[K, V]{
+		table:           make(map[K]*entry[K, V]),
+		order:           list.New(),
+		ttl:             cfg.ttl,
+		capacity:        cfg.capacity,
+		clock:           cfg.clock,
+		removalListener: cfg.removalListener,
+	}
+	if cfg.janitorInterval > 0 {
+		c.startJanitor(cfg.janitorInterval)
+	}
+	return c
+}
+
+func (c *Cache[K, V]) ttlFor(timeout time.Duration) time.Duration {
+	if timeout != 0 {
+		return timeout
+	}
+	return c.ttl
+}
+
+// insertLocked stores value for k, reusing old's slot in the insertion
+// order if one exists, then evicts the oldest entry if doing so pushed the
+// cache over capacity. Caller must hold c.mu.
+func (c *Cache[K, V]) insertLocked(k K, value V, timeout time.Duration, now time.Time) (old *entry[K, V]) {
+	old, existed := c.table[k]
+
+	e := &entry[K, V]{key: k, value: value, expiration: now.Add(c.ttlFor(timeout)), timeout: c.ttlFor(timeout)}
+	if existed {
+		e.order = old.order
+		e.order.Value = e
+	} else {
+		e.order = c.order.PushBack(e)
+	}
+	c.table[k] = e
+
+	if c.capacity > 0 {
+		for len(c.table) > c.capacity {
+			c.evictOldestLocked()
+		}
+	}
+
+	if existed {
+		return old
+	}
+	return nil
+}
+
+func (c *Cache[K, V]) evictOldestLocked() {
+	front := c.order.Front()
+	if front == nil {
+		return
+	}
+	e := front.Value.(*entry[K, V])
+	c.removeLocked(e)
+	if c.removalListener != nil {
+		c.removalListener(e.key, e.value)
+	}
+}
+
+func (c *Cache[K, V]) removeLocked(e *entry[K, V]) {
+	delete(c.table, e.key)
+	c.order.Remove(e.order)
+}
+
+// Put adds the key/value to the cache, replacing any existing value. The
+// entry expires after timeout, or after the cache's default TTL if timeout
+// is 0. The previous value is returned with ok true, or the zero value
+// with ok false if there wasn't one or it had already expired.
+func (c *Cache[K, V]) Put(k K, value V, timeout time.Duration) (old V, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.clock()
+	prev := c.insertLocked(k, value, timeout, now)
+	if prev != nil && !prev.isExpired(now) {
+		return prev.value, true
+	}
+	var zero V
+	return zero, false
+}
+
+// PutIfAbsent adds the key/value to the cache only if there is no
+// non-expired value already stored for k. It returns the existing value
+// with ok true if one was present, or the zero value with ok false if k
+// was absent (in which case value was stored).
+func (c *Cache[K, V]) PutIfAbsent(k K, value V, timeout time.Duration) (old V, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.clock()
+	if prev, existed := c.table[k]; existed && !prev.isExpired(now) {
+		return prev.value, true
+	}
+
+	c.insertLocked(k, value, timeout, now)
+	var zero V
+	return zero, false
+}
+
+// Replace overwrites the value for k only if it is already present,
+// returning the previous value with ok true (ok false if it had already
+// expired), or the zero value with ok false without storing anything if k
+// was absent.
+func (c *Cache[K, V]) Replace(k K, value V, timeout time.Duration) (old V, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.clock()
+	prev, existed := c.table[k]
+	if !existed {
+		var zero V
+		return zero, false
+	}
+
+	c.insertLocked(k, value, timeout, now)
+	if prev.isExpired(now) {
+		var zero V
+		return zero, false
+	}
+	return prev.value, true
+}
+
+// Get returns the value stored for k with ok true, or the zero value with
+// ok false if it is absent or expired.
+func (c *Cache[K, V]) Get(k K) (value V, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, existed := c.table[k]
+	if !existed || e.isExpired(c.clock()) {
+		var zero V
+		return zero, false
+	}
+	return e.value, true
+}
+
+// Delete removes k from the cache and returns its value with ok true, or
+// the zero value with ok false if k was absent or already expired.
+func (c *Cache[K, V]) Delete(k K) (value V, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, existed := c.table[k]
+	if !existed {
+		var zero V
+		return zero, false
+	}
+	c.removeLocked(e)
+
+	if e.isExpired(c.clock()) {
+		var zero V
+		return zero, false
+	}
+	return e.value, true
+}
+
+// Size returns the number of entries in the cache, including expired ones
+// that have not yet been cleaned up.
+func (c *Cache[K, V]) Size() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.table)
+}
+
+// Entries returns a snapshot of the non-expired key/value pairs in the
+// cache.
+func (c *Cache[K, V]) Entries() map[K]V {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.clock()
+	m := make(map[K]V, len(c.table))
+	for k, e := range c.table {
+		if !e.isExpired(now) {
+			m[k] = e.value
+		}
+	}
+	return m
+}
+
+// CleanUp removes every currently expired entry from the cache, invoking
+// the RemovalListener (if any) for each one, and returns the number of
+// entries removed.
+func (c *Cache[K, V]) CleanUp() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.clock()
+	count := 0
+	for k, e := range c.table {
+		if e.isExpired(now) {
+			c.removeLocked(e)
+			count++
+			if c.removalListener != nil {
+				c.removalListener(k, e.value)
+			}
+		}
+	}
+	return count
+}
+
+func (c *Cache[K, V]) startJanitor(interval time.Duration) {
+	c.janitorStop = make(chan struct{})
+	stop := c.janitorStop
+
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				c.CleanUp()
+			}
+		}
+	}()
+}
+
+// StopJanitor stops the janitor goroutine started by WithJanitorInterval.
+// It is a no-op if no janitor is running.
+func (c *Cache[K, V]) StopJanitor() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.janitorStop != nil {
+		close(c.janitorStop)
+		c.janitorStop = nil
+	}
+}
+
+// TypedView adapts an existing *common.Cache to this package's typed API,
+// mirroring Put/PutIfAbsent/Replace/Get/Delete/Entries/CleanUp/Size, so call
+// sites can migrate to the generic Cache one at a time without touching the
+// underlying cache or the other code still sharing it through the
+// interface{} API.
+type TypedView[K comparable, V any] struct {
+	cache *common.Cache
+}
+
+// Wrap returns a TypedView backed by cache.
+func Wrap[K comparable, V any](cache *common.Cache) *TypedView[K, V] {
+	return &TypedView[K, V]{cache: cache}
+}
+
+// Put mirrors common.Cache.Put, type-asserting the previous value to V.
+func (v *TypedView[K, V]) Put(k K, value V, timeout time.Duration) (old V, ok bool) {
+	return asTyped[V](v.cache.Put(k, value, timeout))
+}
+
+// PutIfAbsent mirrors common.Cache.PutIfAbsent, type-asserting the existing
+// value to V.
+func (v *TypedView[K, V]) PutIfAbsent(k K, value V, timeout time.Duration) (old V, ok bool) {
+	return asTyped[V](v.cache.PutIfAbsent(k, value, timeout))
+}
+
+// Replace mirrors common.Cache.Replace, type-asserting the previous value to
+// V.
+func (v *TypedView[K, V]) Replace(k K, value V, timeout time.Duration) (old V, ok bool) {
+	return asTyped[V](v.cache.Replace(k, value, timeout))
+}
+
+// Get mirrors common.Cache.Get, type-asserting the stored value to V.
+func (v *TypedView[K, V]) Get(k K) (value V, ok bool) {
+	return asTyped[V](v.cache.Get(k))
+}
+
+// Delete mirrors common.Cache.Delete, type-asserting the removed value to V.
+func (v *TypedView[K, V]) Delete(k K) (value V, ok bool) {
+	return asTyped[V](v.cache.Delete(k))
+}
+
+// Entries mirrors common.Cache.Entries, type-asserting each stored value to
+// V and silently dropping any entry whose value is not a V.
+func (v *TypedView[K, V]) Entries() map[K]V {
+	entries := v.cache.Entries()
+	m := make(map[K]V, len(entries))
+	for k, value := range entries {
+		typed, ok := asTyped[V](value)
+		key, keyOK := k.(K)
+		if ok && keyOK {
+			m[key] = typed
+		}
+	}
+	return m
+}
+
+// CleanUp mirrors common.Cache.CleanUp.
+func (v *TypedView[K, V]) CleanUp() int {
+	return v.cache.CleanUp()
+}
+
+// Size mirrors common.Cache.Size.
+func (v *TypedView[K, V]) Size() int {
+	return v.cache.Size()
+}
+
+// asTyped converts a common.Value coming back from the interface{} based
+// cache into a (V, bool) pair, treating both a nil value and a value of
+// the wrong type as "not found" rather than panicking.
+func asTyped[V any](value common.Value) (V, bool) {
+	if value == nil {
+		var zero V
+		return zero, false
+	}
+	typed, ok := value.(V)
+	return typed, ok
+}
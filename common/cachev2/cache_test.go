@@ -0,0 +1,162 @@
+package cachev2
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/zzugg/libbeat/common"
+)
+
+const ttl = time.Minute
+
+func TestPutAndGet(t *testing.T) {
+	c := New[string, int](WithTTL[string, int](ttl))
+
+	old, ok := c.Put("a", 1, 0)
+	assert.False(t, ok)
+	assert.Equal(t, 0, old)
+
+	v, ok := c.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 1, v)
+
+	old, ok = c.Put("a", 2, 0)
+	assert.True(t, ok)
+	assert.Equal(t, 1, old)
+}
+
+func TestGetExpired(t *testing.T) {
+	var now time.Time
+	c := New[string, int](WithTTL[string, int](ttl), WithClock[string, int](func() time.Time { return now }))
+
+	c.Put("a", 1, 0)
+	now = now.Add(ttl).Add(time.Nanosecond)
+
+	v, ok := c.Get("a")
+	assert.False(t, ok)
+	assert.Equal(t, 0, v)
+}
+
+func TestPutIfAbsent(t *testing.T) {
+	c := New[string, int](WithTTL[string, int](ttl))
+
+	old, ok := c.PutIfAbsent("a", 1, 0)
+	assert.False(t, ok)
+	assert.Equal(t, 0, old)
+
+	old, ok = c.PutIfAbsent("a", 2, 0)
+	assert.True(t, ok)
+	assert.Equal(t, 1, old)
+
+	v, _ := c.Get("a")
+	assert.Equal(t, 1, v)
+}
+
+func TestReplace(t *testing.T) {
+	c := New[string, int](WithTTL[string, int](ttl))
+
+	old, ok := c.Replace("a", 1, 0)
+	assert.False(t, ok)
+	assert.Equal(t, 0, old)
+	assert.Equal(t, 0, c.Size())
+
+	c.Put("a", 1, 0)
+	old, ok = c.Replace("a", 2, 0)
+	assert.True(t, ok)
+	assert.Equal(t, 1, old)
+}
+
+func TestDelete(t *testing.T) {
+	c := New[string, int](WithTTL[string, int](ttl))
+
+	_, ok := c.Delete("a")
+	assert.False(t, ok)
+
+	c.Put("a", 1, 0)
+	v, ok := c.Delete("a")
+	assert.True(t, ok)
+	assert.Equal(t, 1, v)
+	assert.Equal(t, 0, c.Size())
+}
+
+func TestCapacityEvictsOldest(t *testing.T) {
+	var evicted []string
+	c := New[string, int](
+		WithTTL[string, int](ttl),
+		WithCapacity[string, int](2),
+		WithRemovalListener(func(k string, v int) { evicted = append(evicted, k) }),
+	)
+
+	c.Put("a", 1, 0)
+	c.Put("b", 2, 0)
+	c.Put("c", 3, 0)
+
+	assert.Equal(t, 2, c.Size())
+	assert.Equal(t, []string{"a"}, evicted)
+	_, ok := c.Get("a")
+	assert.False(t, ok)
+}
+
+func TestCleanUp(t *testing.T) {
+	var now time.Time
+	c := New[string, int](WithTTL[string, int](ttl), WithClock[string, int](func() time.Time { return now }))
+
+	c.Put("a", 1, 0)
+	now = now.Add(ttl).Add(time.Nanosecond)
+	c.Put("b", 2, 0)
+
+	assert.Equal(t, 1, c.CleanUp())
+	m := c.Entries()
+	assert.Equal(t, 1, len(m))
+	assert.Equal(t, 2, m["b"])
+}
+
+// Test that a TypedView mirrors the full common.Cache surface, type-asserting
+// values to V along the way.
+func TestTypedViewMirrorsUnderlyingCache(t *testing.T) {
+	underlying := common.NewCache(ttl, 0)
+	v := Wrap[string, int](underlying)
+
+	old, ok := v.Put("a", 1, 0)
+	assert.False(t, ok)
+	assert.Equal(t, 0, old)
+
+	old, ok = v.PutIfAbsent("a", 2, 0)
+	assert.True(t, ok)
+	assert.Equal(t, 1, old)
+
+	old, ok = v.Replace("a", 3, 0)
+	assert.True(t, ok)
+	assert.Equal(t, 1, old)
+
+	value, ok := v.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 3, value)
+
+	v.Put("b", 4, 0)
+	assert.Equal(t, 2, v.Size())
+	assert.Equal(t, map[string]int{"a": 3, "b": 4}, v.Entries())
+
+	old, ok = v.Delete("b")
+	assert.True(t, ok)
+	assert.Equal(t, 4, old)
+	assert.Equal(t, 1, v.Size())
+
+	// The underlying cache is untyped, so it's reachable directly too.
+	underlying.Put("c", "not an int", 0)
+	assert.Equal(t, map[string]int{"a": 3}, v.Entries())
+}
+
+func TestTypedViewCleanUp(t *testing.T) {
+	underlying := common.NewCache(time.Millisecond, 0)
+	v := Wrap[string, int](underlying)
+
+	v.Put("a", 1, 0)
+	time.Sleep(2 * time.Millisecond)
+	v.Put("b", 2, 0)
+
+	assert.Equal(t, 1, v.CleanUp())
+	assert.Equal(t, map[string]int{"b": 2}, v.Entries())
+}